@@ -3,26 +3,84 @@ package cfg
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 const (
 	tagCfg     = "cfg"
 	tagDefault = "default"
+	tagEnv     = "env"
+)
+
+// Source identifies where a config value was resolved from.
+type Source int
+
+const (
+	SourceFile Source = iota
+	SourceEnv
+	SourceDefault
 )
 
+func (s Source) String() string {
+	switch s {
+	case SourceFile:
+		return "file"
+	case SourceEnv:
+		return "env"
+	case SourceDefault:
+		return "default"
+	default:
+		return "unknown"
+	}
+}
+
+//defaultPrecedence is used when ConfigFiles.SetPrecedence was never called.
+//Env wins over file by default so a container can override a baked-in
+//config file without rebuilding it.
+var defaultPrecedence = []Source{SourceEnv, SourceFile, SourceDefault}
+
 // ConfigFiles represents multiple file containing the config keys and values
 type ConfigFiles struct {
 	Files []File
+
+	envPrefix  string
+	precedence []Source
+	mu         *sync.RWMutex
+}
+
+//EnvPrefix sets a prefix which is prepended to every environment variable
+//lookup, e.g. EnvPrefix("MYAPP_") looks up MYAPP_SERVER_PORT instead of SERVER_PORT
+func (c *ConfigFiles) EnvPrefix(prefix string) {
+	c.envPrefix = prefix
+}
+
+//SetPrecedence configures the order in which sources are checked for a value.
+//The first source in the chain which provides a value for a key wins.
+//If not called, the precedence defaults to SourceEnv, SourceFile, SourceDefault.
+func (c *ConfigFiles) SetPrecedence(sources ...Source) {
+	c.precedence = sources
+}
+
+func (c ConfigFiles) orderedPrecedence() []Source {
+	if len(c.precedence) == 0 {
+		return defaultPrecedence
+	}
+	return c.precedence
 }
 
 // File represents a file
@@ -33,10 +91,11 @@ type File struct {
 	Required bool
 }
 
-// Default represents a default value for a field
+// Default represents the value applied to a field because no higher
+// precedence source provided one, along with the Source it was resolved from.
 type Default struct {
-	Value string
-	field reflect.Value
+	Value  string
+	Source Source
 }
 
 // CustomType can be implemented to unmarshal in a custom format
@@ -134,10 +193,12 @@ func (c *ConfigFiles) AddConfig(path, name string, required bool) {
 	c.Files = append(c.Files, f)
 }
 
-//MergeConfigsInto merges multiple configs files into a struct
-//returns the applied default values
+//MergeConfigsInto merges multiple config files and the environment into a struct.
+//The precedence among file, environment and default values is controlled by
+//SetPrecedence and defaults to SourceEnv, SourceFile, SourceDefault.
+//returns the keys which were not sourced from a file, and where they came from
 func (c ConfigFiles) MergeConfigsInto(dest interface{}) (map[string]Default, error) {
-	kvs := make(map[string]string)
+	kvs := make(ParsedConfig)
 
 	for _, v := range c.Files {
 		f, err := os.Open(filepath.Join(v.Path, v.Name))
@@ -151,7 +212,7 @@ func (c ConfigFiles) MergeConfigsInto(dest interface{}) (map[string]Default, err
 
 		defer f.Close()
 
-		kv, err := parse(f, dest)
+		kv, err := parserFor(v.Name).Parse(f)
 
 		if err != nil {
 			return nil, err
@@ -163,7 +224,7 @@ func (c ConfigFiles) MergeConfigsInto(dest interface{}) (map[string]Default, err
 	}
 
 	defaults := make(map[string]Default)
-	err := setFields(kvs, defaults, dest)
+	err := setFields(kvs, defaults, dest, c.envPrefix, c.orderedPrecedence())
 
 	if err != nil {
 		return nil, err
@@ -172,8 +233,11 @@ func (c ConfigFiles) MergeConfigsInto(dest interface{}) (map[string]Default, err
 	return defaults, nil
 }
 
-//LoadConfigInto loads a single config into struct
-//returns the applied default values
+//LoadConfigInto loads a single config file, overlaid with environment
+//variables, into a struct. The precedence defaults to
+//SourceEnv, SourceFile, SourceDefault and is not configurable for this helper;
+//use ConfigFiles.SetPrecedence if a different order is required.
+//returns the keys which were not sourced from a file, and where they came from
 func LoadConfigInto(file string, dest interface{}) (map[string]Default, error) {
 	f, err := os.Open(file)
 
@@ -183,7 +247,7 @@ func LoadConfigInto(file string, dest interface{}) (map[string]Default, error) {
 
 	defer f.Close()
 
-	kvs, err := parse(f, dest)
+	kvs, err := parserFor(file).Parse(f)
 
 	if err != nil {
 		return nil, err
@@ -191,7 +255,7 @@ func LoadConfigInto(file string, dest interface{}) (map[string]Default, error) {
 
 	defaults := make(map[string]Default)
 
-	err = setFields(kvs, defaults, dest)
+	err = setFields(kvs, defaults, dest, "", defaultPrecedence)
 
 	if err != nil {
 		return nil, err
@@ -200,9 +264,220 @@ func LoadConfigInto(file string, dest interface{}) (map[string]Default, error) {
 	return defaults, nil
 }
 
-func parse(file *os.File, dest interface{}) (map[string]string, error) {
-	scanner := bufio.NewScanner(file)
-	kvmap := make(map[string]string)
+//Snapshot runs fn while holding a read lock over the struct most recently
+//populated by Watch, so fn never observes a struct that is only half
+//reloaded. It is a no-op lock if Watch was never started and Lock was
+//never called either.
+func (c *ConfigFiles) Snapshot(fn func()) {
+	if c.mu == nil {
+		fn()
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fn()
+}
+
+//Lock runs fn while holding a write lock over the struct most recently
+//populated by Watch. Callers that mutate individual fields of a struct
+//passed to Watch concurrently with it running, such as cfg/vfs writing
+//through a live Node, must do so inside Lock, otherwise the write races
+//with Watch's own reload. Unlike Snapshot, Lock always synchronizes,
+//lazily creating the mutex on first use, since there is no safe way to
+//tell a real concurrent writer from "Watch was never started".
+func (c *ConfigFiles) Lock(fn func()) {
+	if c.mu == nil {
+		c.mu = &sync.RWMutex{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fn()
+}
+
+//Watch uses fsnotify to watch every configured file's directory and re-runs
+//MergeConfigsInto (picking up any environment variable changes too) whenever
+//one of them changes. The reload is applied under the same lock Snapshot
+//reads through, so readers never see a partially updated struct. onChange is
+//called with the cfg keys whose value changed, or with a non-nil error if
+//the reload itself failed or the underlying fsnotify watcher reported one.
+func (c *ConfigFiles) Watch(dest interface{}, onChange func(changed []string, err error)) (stop func(), err error) {
+	w, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return nil, err
+	}
+
+	watchedDirs := make(map[string]bool)
+
+	for _, f := range c.Files {
+		dir := f.Path
+
+		if len(dir) == 0 {
+			dir = "."
+		}
+
+		if watchedDirs[dir] {
+			continue
+		}
+
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, err
+		}
+
+		watchedDirs[dir] = true
+	}
+
+	if c.mu == nil {
+		c.mu = &sync.RWMutex{}
+	}
+
+	prev := snapshotLeaves(dest)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+
+				c.mu.Lock()
+				_, err := c.MergeConfigsInto(dest)
+				next := snapshotLeaves(dest)
+				c.mu.Unlock()
+
+				if err != nil {
+					onChange(nil, err)
+					continue
+				}
+
+				if changed := diffLeaves(prev, next); len(changed) > 0 {
+					onChange(changed, nil)
+				}
+
+				prev = next
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+
+				onChange(nil, err)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	stop = func() {
+		close(done)
+		w.Close()
+	}
+
+	return stop, nil
+}
+
+//snapshotLeaves walks dest and returns a flat map of cfg key to its current
+//string representation, used by Watch to tell which keys changed on reload.
+func snapshotLeaves(dest interface{}) map[string]string {
+	v := reflect.ValueOf(dest)
+
+	if v.Kind() != reflect.Ptr {
+		return nil
+	}
+
+	out := make(map[string]string)
+	walkLeaves(v.Elem(), out)
+	return out
+}
+
+func walkLeaves(el reflect.Value, out map[string]string) {
+	for i := 0; i < el.NumField(); i++ {
+		field := el.Field(i)
+		sf := el.Type().Field(i)
+
+		if field.Kind() == reflect.Struct && field.Type() != timeType {
+			walkLeaves(field, out)
+			continue
+		}
+
+		sKey, _ := parseCfgTag(sf.Tag.Get(tagCfg))
+
+		if sKey == "-" {
+			continue
+		}
+
+		if len(sKey) == 0 {
+			sKey = sf.Name
+		}
+
+		out[sKey] = fmt.Sprintf("%v", field.Interface())
+	}
+}
+
+//diffLeaves returns the sorted cfg keys whose string representation differs
+//between two snapshots taken by snapshotLeaves.
+func diffLeaves(prev, next map[string]string) []string {
+	var changed []string
+
+	for k, v := range next {
+		if pv, ok := prev[k]; !ok || pv != v {
+			changed = append(changed, k)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// ParsedConfig is the nested representation a Parser produces. Leaf values
+// are strings; a key whose value is itself a ParsedConfig represents a
+// section, which setFields descends into for the matching struct field.
+type ParsedConfig map[string]interface{}
+
+// Parser turns the contents of a config file into a ParsedConfig tree.
+// cfg picks the Parser to use for a file by its extension, see RegisterParser.
+type Parser interface {
+	Parse(r io.Reader) (ParsedConfig, error)
+}
+
+var parsers = map[string]Parser{
+	".json": jsonParser{},
+	".yaml": yamlParser{},
+	".yml":  yamlParser{},
+}
+
+//RegisterParser registers a Parser for a file extension, including the
+//leading dot, e.g. RegisterParser(".toml", tomlParser{}). Registering a
+//Parser for an extension that already has one, such as the builtin .json
+//or .yaml/.yml parsers, replaces it.
+func RegisterParser(ext string, p Parser) {
+	parsers[ext] = p
+}
+
+func parserFor(name string) Parser {
+	if p, ok := parsers[filepath.Ext(name)]; ok {
+		return p
+	}
+	return iniParser{}
+}
+
+// iniParser is the default, dependency-free key=value parser and is used
+// for .conf files and any extension without a registered Parser.
+type iniParser struct{}
+
+func (iniParser) Parse(r io.Reader) (ParsedConfig, error) {
+	scanner := bufio.NewScanner(r)
+	kvmap := make(ParsedConfig)
 
 	for scanner.Scan() {
 		line := scanner.Bytes()
@@ -234,7 +509,163 @@ func parse(file *os.File, dest interface{}) (map[string]string, error) {
 	return kvmap, nil
 }
 
-func setFields(kv map[string]string, defaults map[string]Default, dest interface{}) error {
+// jsonParser decodes a JSON object into a ParsedConfig, keeping nested
+// objects as nested ParsedConfig sections and stringifying scalar leaves.
+type jsonParser struct{}
+
+func (jsonParser) Parse(r io.Reader) (ParsedConfig, error) {
+	var raw map[string]interface{}
+
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	return normalize(raw), nil
+}
+
+//normalize turns the map[string]interface{} produced by encoding/json into
+//the string/ParsedConfig leaves setFields expects, joining arrays with a
+//comma so they can be picked up by the slice support in setField.
+func normalize(raw map[string]interface{}) ParsedConfig {
+	out := make(ParsedConfig, len(raw))
+
+	for k, v := range raw {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			out[k] = normalize(val)
+		case []interface{}:
+			parts := make([]string, len(val))
+			for i, e := range val {
+				parts[i] = fmt.Sprint(e)
+			}
+			out[k] = strings.Join(parts, ",")
+		default:
+			out[k] = fmt.Sprint(val)
+		}
+	}
+
+	return out
+}
+
+// yamlParser is a dependency-free parser for the subset of YAML this
+// package needs: indentation-nested mappings and scalar leaves, with
+// flow-style (`[a, b]`) or block-style (`- a`) sequences joined with a
+// comma so they reach the same slice support in setField as the other
+// formats. It does not support anchors, tags, multi-document streams or
+// multi-line scalars.
+type yamlParser struct{}
+
+func (yamlParser) Parse(r io.Reader) (ParsedConfig, error) {
+	scanner := bufio.NewScanner(r)
+	root := make(ParsedConfig)
+
+	type frame struct {
+		indent int
+		m      ParsedConfig
+	}
+	stack := []frame{{indent: -1, m: root}}
+
+	type pendingBlock struct {
+		indent int
+		m      ParsedConfig
+		key    string
+		items  []string
+	}
+	var pending *pendingBlock
+
+	flushPending := func() {
+		if pending != nil && len(pending.items) > 0 {
+			pending.m[pending.key] = strings.Join(pending.items, ",")
+		}
+		pending = nil
+	}
+
+	for scanner.Scan() {
+		raw := scanner.Text()
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		content := strings.TrimSpace(raw)
+
+		if len(content) == 0 || content[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			item := yamlUnquote(strings.TrimSpace(strings.TrimPrefix(content, "-")))
+
+			if pending != nil && indent > pending.indent {
+				pending.items = append(pending.items, item)
+				continue
+			}
+
+			return nil, fmt.Errorf("yaml: unexpected list item %q", raw)
+		}
+
+		flushPending()
+
+		for len(stack) > 1 && indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		cur := stack[len(stack)-1].m
+
+		parts := strings.SplitN(content, ":", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("yaml: invalid line %q", raw)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		if len(value) == 0 {
+			nested := make(ParsedConfig)
+			cur[key] = nested
+			stack = append(stack, frame{indent: indent, m: nested})
+			pending = &pendingBlock{indent: indent, m: cur, key: key}
+			continue
+		}
+
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			inner := strings.TrimSpace(value[1 : len(value)-1])
+
+			if len(inner) == 0 {
+				cur[key] = ""
+				continue
+			}
+
+			items := strings.Split(inner, ",")
+			for i, it := range items {
+				items[i] = yamlUnquote(strings.TrimSpace(it))
+			}
+			cur[key] = strings.Join(items, ",")
+			continue
+		}
+
+		cur[key] = yamlUnquote(value)
+	}
+
+	flushPending()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+//yamlUnquote strips a single layer of surrounding single or double quotes,
+//if present.
+func yamlUnquote(value string) string {
+	if len(value) >= 2 {
+		if (value[0] == '"' && value[len(value)-1] == '"') || (value[0] == '\'' && value[len(value)-1] == '\'') {
+			return value[1 : len(value)-1]
+		}
+	}
+
+	return value
+}
+
+func setFields(kv ParsedConfig, defaults map[string]Default, dest interface{}, envPrefix string, precedence []Source) error {
 	v := reflect.ValueOf(dest)
 
 	if v.Kind() != reflect.Ptr {
@@ -244,64 +675,146 @@ func setFields(kv map[string]string, defaults map[string]Default, dest interface
 	el := v.Elem()
 
 	for i := 0; i < el.NumField(); i++ {
-		if el.Field(i).Kind() == reflect.Struct {
-			err := setFields(kv, defaults, el.Field(i).Addr().Interface())
+		if el.Field(i).Kind() == reflect.Struct && el.Type().Field(i).Type != timeType {
+			nested := nestedSection(kv, el.Type().Field(i))
+
+			err := setFields(nested, defaults, el.Field(i).Addr().Interface(), envPrefix, precedence)
 			if err != nil {
 				return err
 			}
 			continue
 		}
 		if el.Field(i).CanSet() {
-			sKey := el.Type().Field(i).Tag.Get(tagCfg)
-			defValue := el.Type().Field(i).Tag.Get(tagDefault)
+			tag := el.Type().Field(i)
+			sKey, cfgOpts := parseCfgTag(tag.Tag.Get(tagCfg))
+			defValue := tag.Tag.Get(tagDefault)
 
 			if sKey == "-" {
 				continue
 			}
 
 			if len(sKey) == 0 {
-				sKey = el.Type().Field(i).Name
+				sKey = tag.Name
 			}
 
-			def := Default{}
+			envKey := tag.Tag.Get(tagEnv)
 
-			if len(defValue) > 0 {
-				def = Default{
-					Value: defValue,
-					field: el.Field(i),
-				}
+			if len(envKey) == 0 {
+				envKey = strings.ToUpper(sKey)
+			}
+
+			envKey = envPrefix + envKey
 
-				defaults[sKey] = def
+			value, src, ok := resolveValue(precedence, kv, sKey, envKey, defValue)
+
+			if !ok {
+				continue
 			}
 
-			value, ok := kv[sKey]
+			err := setField(el.Field(i), value, cfgOpts)
 
-			if ok {
-				err := setField(el.Field(i), value)
+			if err != nil {
+				if src != SourceDefault && len(defValue) > 0 {
+					//fall back to the default value if the higher precedence source was unparsable
+					value = defValue
+					src = SourceDefault
+					err = setField(el.Field(i), value, cfgOpts)
+				}
 
 				if err != nil {
-					if def != (Default{}) {
-						//ignore error here if key has a default
-						continue
+					if src == SourceDefault {
+						return fmt.Errorf("error while setting default value [%s] for key [%s] error %v", value, sKey, err)
 					}
 					return fmt.Errorf("error while setting value [%s] for key [%s] error %v", value, sKey, err)
 				}
+			}
 
-				delete(defaults, sKey)
+			if src != SourceFile {
+				defaults[sKey] = Default{
+					Value:  value,
+					Source: src,
+				}
 			}
 		}
 	}
-	for k, d := range defaults {
-		err := setField(d.field, d.Value)
-		if err != nil {
-			return fmt.Errorf("error while setting default value [%s] for key [%s] error %v", d.Value, k, err)
+
+	return nil
+}
+
+//nestedSection returns the ParsedConfig section a nested struct field should
+//be resolved against. A section is looked up under the field's cfg tag, or
+//its lowercased name if no tag was given; if there is no matching section
+//the same map is reused, which keeps the flat-key convention (e.g.
+//Server.Port via cfg:"server_port") working for formats like the default
+//key=value parser that never produce nested sections.
+func nestedSection(kv ParsedConfig, field reflect.StructField) ParsedConfig {
+	sectionKey, _ := parseCfgTag(field.Tag.Get(tagCfg))
+
+	if len(sectionKey) == 0 {
+		sectionKey = strings.ToLower(field.Name)
+	}
+
+	if section, ok := kv[sectionKey]; ok {
+		if nested, ok := section.(ParsedConfig); ok {
+			return nested
 		}
 	}
 
-	return nil
+	return kv
+}
+
+//parseCfgTag splits a cfg tag into its key and its comma-separated options,
+//e.g. `cfg:"hosts,sep=;"` yields ("hosts", map[string]string{"sep": ";"}).
+func parseCfgTag(raw string) (string, map[string]string) {
+	parts := strings.Split(raw, ",")
+	opts := make(map[string]string, len(parts)-1)
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+		}
+	}
+
+	return parts[0], opts
 }
 
-func setField(field reflect.Value, value string) error {
+//resolveValue walks the precedence chain and returns the value of the first
+//source which provides one for the key, along with that Source.
+func resolveValue(precedence []Source, kv ParsedConfig, sKey, envKey, defValue string) (string, Source, bool) {
+	for _, src := range precedence {
+		switch src {
+		case SourceFile:
+			if raw, ok := kv[sKey]; ok {
+				if value, ok := raw.(string); ok {
+					return value, SourceFile, true
+				}
+			}
+		case SourceEnv:
+			if value, ok := os.LookupEnv(envKey); ok {
+				return value, SourceEnv, true
+			}
+		case SourceDefault:
+			if len(defValue) > 0 {
+				return defValue, SourceDefault, true
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+//SetField reparses value into field using the same coercion rules cfg uses
+//when loading config files (CustomType, time.Time, slices, maps, durations,
+//FileSize suffixes, ...). Exported so packages such as cfg/vfs can replay a
+//single field update after the struct has already been loaded.
+func SetField(field reflect.Value, value string, opts map[string]string) error {
+	return setField(field, value, opts)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func setField(field reflect.Value, value string, opts map[string]string) error {
 	customType := reflect.TypeOf((*CustomType)(nil)).Elem()
 
 	if reflect.PtrTo(field.Type()).Implements(customType) {
@@ -315,9 +828,68 @@ func setField(field reflect.Value, value string) error {
 		return nil
 	}
 
+	if field.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			return err
+		}
+
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
 	switch field.Kind() {
 	case reflect.String:
 		field.SetString(value)
+	case reflect.Slice:
+		sep := ","
+
+		if s, ok := opts["sep"]; ok && len(s) > 0 {
+			sep = s
+		}
+
+		if len(value) == 0 {
+			field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+			return nil
+		}
+
+		parts := strings.Split(value, sep)
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+
+		for i, p := range parts {
+			if err := setField(slice.Index(i), strings.TrimSpace(p), nil); err != nil {
+				return err
+			}
+		}
+
+		field.Set(slice)
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", field.Type().Key())
+		}
+
+		m := reflect.MakeMap(field.Type())
+
+		if len(value) > 0 {
+			for _, pair := range strings.Split(value, ",") {
+				kv := strings.SplitN(pair, ":", 2)
+
+				if len(kv) != 2 {
+					return fmt.Errorf("invalid map entry [%s], expected key:value", pair)
+				}
+
+				elem := reflect.New(field.Type().Elem()).Elem()
+
+				if err := setField(elem, strings.TrimSpace(kv[1]), nil); err != nil {
+					return err
+				}
+
+				m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])), elem)
+			}
+		}
+
+		field.Set(m)
 	case reflect.Int8, reflect.Int16, reflect.Int, reflect.Int64:
 		d, err := time.ParseDuration(value)
 
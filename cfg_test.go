@@ -1,6 +1,9 @@
 package cfg_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -203,6 +206,244 @@ func TestFileSizes(t *testing.T) {
 
 }
 
+func TestEnvOverlay(t *testing.T) {
+	type settings struct {
+		Port    int    `cfg:"port" env:"APP_PORT" default:"2000"`
+		Verbose bool   `cfg:"verbose" default:"no"`
+		Region  string `cfg:"region" default:"eu"`
+	}
+
+	os.Setenv("APP_PORT", "9090")
+	os.Setenv("VERBOSE", "yes")
+	defer os.Unsetenv("APP_PORT")
+	defer os.Unsetenv("VERBOSE")
+
+	c := addConfig("./testcfg", "config.conf")
+
+	s := new(settings)
+
+	def, err := c.MergeConfigsInto(s)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s.Port != 9090 {
+		t.Errorf("Port expected to be 9090 but was %d", s.Port)
+	}
+
+	if !s.Verbose {
+		t.Errorf("Verbose expected to be true but was %t", s.Verbose)
+	}
+
+	if s.Region != "eu" {
+		t.Errorf("Region expected to be eu but was %s", s.Region)
+	}
+
+	if def["port"].Source != cfg.SourceEnv {
+		t.Errorf("port expected to be sourced from env but was %s", def["port"].Source)
+	}
+
+	if def["verbose"].Source != cfg.SourceEnv {
+		t.Errorf("verbose expected to be sourced from env but was %s", def["verbose"].Source)
+	}
+
+	if def["region"].Source != cfg.SourceDefault {
+		t.Errorf("region expected to be sourced from default but was %s", def["region"].Source)
+	}
+}
+
+func TestEnvPrefixAndPrecedence(t *testing.T) {
+	type settings struct {
+		Port int `cfg:"port" default:"2000"`
+	}
+
+	os.Setenv("MYAPP_PORT", "7070")
+	defer os.Unsetenv("MYAPP_PORT")
+
+	c := addConfig("./testcfg", "config.conf")
+	c.EnvPrefix("MYAPP_")
+	c.SetPrecedence(cfg.SourceEnv, cfg.SourceFile, cfg.SourceDefault)
+
+	s := new(settings)
+
+	def, err := c.MergeConfigsInto(s)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s.Port != 7070 {
+		t.Errorf("Port expected to be 7070 (env wins over file) but was %d", s.Port)
+	}
+
+	if def["port"].Source != cfg.SourceEnv {
+		t.Errorf("port expected to be sourced from env but was %s", def["port"].Source)
+	}
+}
+
+func TestJSONParser(t *testing.T) {
+	type settings struct {
+		Server struct {
+			Port int `cfg:"port"`
+		}
+		FeatureFlags string `cfg:"feature_flags"`
+	}
+
+	c := addConfig("./testcfg", "config.json")
+
+	s := new(settings)
+
+	_, err := c.MergeConfigsInto(s)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s.Server.Port != 9443 {
+		t.Errorf("Server.Port expected to be 9443 but was %d", s.Server.Port)
+	}
+
+	if s.FeatureFlags != "beta,dark_mode" {
+		t.Errorf("FeatureFlags expected to be beta,dark_mode but was %s", s.FeatureFlags)
+	}
+}
+
+func TestYAMLParser(t *testing.T) {
+	type settings struct {
+		Server struct {
+			Port int `cfg:"port"`
+		}
+		FeatureFlags string `cfg:"feature_flags"`
+	}
+
+	c := addConfig("./testcfg", "config.yaml")
+
+	s := new(settings)
+
+	_, err := c.MergeConfigsInto(s)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if s.Server.Port != 9443 {
+		t.Errorf("Server.Port expected to be 9443 but was %d", s.Server.Port)
+	}
+
+	if s.FeatureFlags != "beta,dark_mode" {
+		t.Errorf("FeatureFlags expected to be beta,dark_mode but was %s", s.FeatureFlags)
+	}
+}
+
+func TestSliceMapAndTime(t *testing.T) {
+	type settings struct {
+		AllowedIPs []string       `cfg:"allowed_ips"`
+		Hosts      []string       `cfg:"hosts,sep=;"`
+		Sizes      []cfg.FileSize `cfg:"sizes"`
+		Limits     map[string]int `cfg:"limits"`
+		StartedAt  time.Time      `cfg:"started_at"`
+	}
+
+	c := addConfig("./testcfg", "config.conf")
+
+	s := new(settings)
+
+	_, err := c.MergeConfigsInto(s)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(s.AllowedIPs) != 2 || s.AllowedIPs[0] != "10.0.0.1" || s.AllowedIPs[1] != "10.0.0.2" {
+		t.Errorf("AllowedIPs expected to be [10.0.0.1 10.0.0.2] but was %v", s.AllowedIPs)
+	}
+
+	if len(s.Hosts) != 2 || s.Hosts[0] != "a.example.com" || s.Hosts[1] != "b.example.com" {
+		t.Errorf("Hosts expected to be [a.example.com b.example.com] but was %v", s.Hosts)
+	}
+
+	if len(s.Sizes) != 2 || s.Sizes[0] != cfg.FileSize(1<<20) || s.Sizes[1] != cfg.FileSize(1<<30) {
+		t.Errorf("Sizes expected to be [1MB 1GB] but was %v", s.Sizes)
+	}
+
+	if s.Limits["read"] != 10 || s.Limits["write"] != 5 {
+		t.Errorf("Limits expected to be {read:10 write:5} but was %v", s.Limits)
+	}
+
+	expStartedAt, _ := time.Parse(time.RFC3339, "2020-01-02T15:04:05Z")
+	if !s.StartedAt.Equal(expStartedAt) {
+		t.Errorf("StartedAt expected to be %v but was %v", expStartedAt, s.StartedAt)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	type settings struct {
+		Port int `cfg:"port" default:"2000"`
+	}
+
+	dir, err := ioutil.TempDir("", "cfg-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgFile := filepath.Join(dir, "watch.conf")
+
+	if err := ioutil.WriteFile(cfgFile, []byte("port=8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := cfg.ConfigFiles{}
+	c.AddConfig(dir, "watch.conf", true)
+
+	s := new(settings)
+
+	if _, err := c.MergeConfigsInto(s); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan []string, 1)
+
+	stop, err := c.Watch(s, func(changed []string, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		changes <- changed
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if err := ioutil.WriteFile(cfgFile, []byte("port=9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case changed := <-changes:
+		c.Snapshot(func() {
+			if s.Port != 9090 {
+				t.Errorf("Port expected to be 9090 after reload but was %d", s.Port)
+			}
+		})
+
+		found := false
+		for _, k := range changed {
+			if k == "port" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected changed to contain port but was %v", changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the watch callback")
+	}
+}
+
 func addConfig(path, filename string) cfg.ConfigFiles {
 	cfg := cfg.ConfigFiles{
 		Files: make([]cfg.File, 0, 1),
@@ -0,0 +1,272 @@
+// Package vfs exposes a struct already populated by cfg.MergeConfigsInto or
+// cfg.LoadConfigInto as a virtual filesystem, so operators can inspect and
+// tweak runtime config without restarting the process. Directories
+// correspond to nested struct fields, leaves to the individual cfg-tagged
+// fields; a leaf is writable when the underlying field CanSet().
+//
+// If the same struct is also passed to a cfg.ConfigFiles.Watch, mount the
+// tree with MountWatched instead of Mount: it synchronizes every Get/Set
+// against the ConfigFiles' lock, so a live reload and a write through the
+// vfs can never race on the same field.
+package vfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"git.hoogi.eu/snafu/cfg"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Node is a single entry in the config virtual filesystem. Leaf nodes wrap
+// a struct field; directory nodes group the nested struct fields beneath them.
+type Node struct {
+	Name     string
+	Value    reflect.Value
+	Writable bool
+	Opts     map[string]string
+	Children map[string]*Node
+
+	cf *cfg.ConfigFiles
+}
+
+func (n *Node) isDir() bool {
+	return n.Children != nil
+}
+
+//Mount walks dest, a pointer to the struct cfg loaded into, and returns the
+//root Node of the resulting tree.
+func Mount(dest interface{}) (*Node, error) {
+	v := reflect.ValueOf(dest)
+
+	if v.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("vfs: dest must be a pointer")
+	}
+
+	root := &Node{Name: "/", Children: make(map[string]*Node)}
+
+	walk(root, v.Elem())
+
+	return root, nil
+}
+
+//MountWatched is Mount, except every Get/Set on the returned tree additionally
+//takes cf's lock, the same one cf.Watch reloads dest under. Use this instead
+//of Mount whenever dest is also passed to cf.Watch, so a live reload and a
+//write through the vfs never race on the same field.
+func MountWatched(dest interface{}, cf *cfg.ConfigFiles) (*Node, error) {
+	root, err := Mount(dest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root.attachLock(cf)
+
+	return root, nil
+}
+
+func (n *Node) attachLock(cf *cfg.ConfigFiles) {
+	n.cf = cf
+
+	for _, child := range n.Children {
+		child.attachLock(cf)
+	}
+}
+
+func walk(parent *Node, el reflect.Value) {
+	for i := 0; i < el.NumField(); i++ {
+		field := el.Field(i)
+		sf := el.Type().Field(i)
+
+		key, opts := parseCfgTag(sf.Tag.Get("cfg"))
+
+		if key == "-" {
+			continue
+		}
+
+		if len(key) == 0 {
+			key = strings.ToLower(sf.Name)
+		}
+
+		if field.Kind() == reflect.Struct && field.Type() != timeType {
+			child := &Node{Name: key, Children: make(map[string]*Node)}
+			walk(child, field)
+			parent.Children[key] = child
+			continue
+		}
+
+		parent.Children[key] = &Node{
+			Name:     key,
+			Value:    field,
+			Writable: field.CanSet(),
+			Opts:     opts,
+		}
+	}
+}
+
+//parseCfgTag splits a cfg tag into its key and its comma-separated options,
+//e.g. `cfg:"hosts,sep=;"` yields ("hosts", map[string]string{"sep": ";"}),
+//mirroring how cfg itself reads the same tag when loading from a file.
+func parseCfgTag(raw string) (string, map[string]string) {
+	parts := strings.Split(raw, ",")
+	opts := make(map[string]string, len(parts)-1)
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) == 2 {
+			opts[kv[0]] = kv[1]
+		}
+	}
+
+	return parts[0], opts
+}
+
+//Get returns the string representation of the leaf at path, e.g. "server/port".
+func (root *Node) Get(path string) (string, error) {
+	n, err := root.find(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	if n.isDir() {
+		return "", fmt.Errorf("vfs: %s is a directory", path)
+	}
+
+	var value string
+	read := func() { value = fmt.Sprintf("%v", n.Value.Interface()) }
+
+	if n.cf != nil {
+		n.cf.Snapshot(read)
+	} else {
+		read()
+	}
+
+	return value, nil
+}
+
+//Set reparses value into the leaf at path, reusing cfg's own coercion rules
+//(CustomType.Unmarshal, durations, FileSize suffixes, slices, maps, ...).
+func (root *Node) Set(path, value string) error {
+	n, err := root.find(path)
+
+	if err != nil {
+		return err
+	}
+
+	if n.isDir() {
+		return fmt.Errorf("vfs: %s is a directory", path)
+	}
+
+	if !n.Writable {
+		return fmt.Errorf("vfs: %s is not writable", path)
+	}
+
+	var setErr error
+	write := func() { setErr = cfg.SetField(n.Value, value, n.Opts) }
+
+	if n.cf != nil {
+		n.cf.Lock(write)
+	} else {
+		write()
+	}
+
+	return setErr
+}
+
+func (root *Node) find(path string) (*Node, error) {
+	n := root
+
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if len(part) == 0 {
+			continue
+		}
+
+		if !n.isDir() {
+			return nil, fmt.Errorf("vfs: %s not found", path)
+		}
+
+		child, ok := n.Children[part]
+
+		if !ok {
+			return nil, fmt.Errorf("vfs: %s not found", path)
+		}
+
+		n = child
+	}
+
+	return n, nil
+}
+
+//Handler exposes the tree over HTTP: GET /<path> reads a leaf's value or
+//lists a directory's children, PUT /<path> reparses the request body into
+//a writable leaf.
+func (root *Node) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+
+		n, err := root.find(path)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if n.isDir() {
+				for name := range n.Children {
+					fmt.Fprintln(w, name)
+				}
+				return
+			}
+
+			read := func() { fmt.Fprintln(w, fmt.Sprintf("%v", n.Value.Interface())) }
+
+			if n.cf != nil {
+				n.cf.Snapshot(read)
+			} else {
+				read()
+			}
+		case http.MethodPut:
+			if n.isDir() {
+				http.Error(w, fmt.Sprintf("vfs: %s is a directory", path), http.StatusBadRequest)
+				return
+			}
+
+			if !n.Writable {
+				http.Error(w, fmt.Sprintf("vfs: %s is not writable", path), http.StatusForbidden)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var setErr error
+			write := func() { setErr = cfg.SetField(n.Value, strings.TrimSpace(string(body)), n.Opts) }
+
+			if n.cf != nil {
+				n.cf.Lock(write)
+			} else {
+				write()
+			}
+
+			if setErr != nil {
+				http.Error(w, setErr.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "vfs: method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package vfs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.hoogi.eu/snafu/cfg"
+	"git.hoogi.eu/snafu/cfg/vfs"
+)
+
+type settings struct {
+	Server struct {
+		Port int `cfg:"port"`
+	}
+	LogLevel string   `cfg:"log_level"`
+	Hosts    []string `cfg:"hosts,sep=;"`
+}
+
+func TestMountGetSet(t *testing.T) {
+	s := &settings{}
+	s.Server.Port = 8080
+	s.LogLevel = "info"
+
+	root, err := vfs.Mount(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := root.Get("server/port")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != "8080" {
+		t.Errorf("expected 8080 but got %s", v)
+	}
+
+	if err := root.Set("log_level", "debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.LogLevel != "debug" {
+		t.Errorf("expected debug but got %s", s.LogLevel)
+	}
+
+	if _, err := root.Get("server"); err == nil {
+		t.Error("expected an error reading a directory as a leaf")
+	}
+}
+
+func TestSetReusesTagOptions(t *testing.T) {
+	s := &settings{}
+
+	root, err := vfs.Mount(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := root.Set("hosts", "c.example.com;d.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Hosts) != 2 || s.Hosts[0] != "c.example.com" || s.Hosts[1] != "d.example.com" {
+		t.Errorf("expected Hosts to be split on ';' but was %v", s.Hosts)
+	}
+}
+
+//TestMountWatchedSynchronizesWithReload exercises vfs.MountWatched's whole
+//point: Set and a cfg.ConfigFiles.Watch-driven reload of the very same
+//struct, running concurrently, must not race on the underlying field. Run
+//with -race to verify; it is also exercised as part of the normal suite.
+func TestMountWatchedSynchronizesWithReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfs-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgFile := filepath.Join(dir, "watch.conf")
+
+	if err := ioutil.WriteFile(cfgFile, []byte("port=8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type watched struct {
+		Port int `cfg:"port"`
+	}
+
+	c := cfg.ConfigFiles{}
+	c.AddConfig(dir, "watch.conf", true)
+
+	s := new(watched)
+
+	if _, err := c.MergeConfigsInto(s); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := vfs.MountWatched(s, &c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := c.Watch(s, func(changed []string, err error) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := root.Set("port", strconv.Itoa(9000+i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 50; i++ {
+		body := []byte(fmt.Sprintf("port=%d\n", 8000+i))
+		if err := ioutil.WriteFile(cfgFile, body, 0644); err != nil {
+			t.Error(err)
+		}
+	}
+
+	wg.Wait()
+
+	if _, err := root.Get("port"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := &settings{}
+	s.Server.Port = 8080
+
+	root, err := vfs.Mount(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(root.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/server/port", "text/plain", strings.NewReader("9090"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be rejected but got %d", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/server/port", strings.NewReader("9090"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the PUT to succeed but got %d", resp.StatusCode)
+	}
+
+	if s.Server.Port != 9090 {
+		t.Errorf("expected Server.Port to be 9090 but was %d", s.Server.Port)
+	}
+}